@@ -0,0 +1,226 @@
+package scopes
+
+// #include <stdlib.h>
+// #include "shim.h"
+import "C"
+import (
+	"encoding/json"
+	"runtime"
+	"time"
+	"unsafe"
+)
+
+// Location represents a physical location reported by the client,
+// as carried by SearchMetadata.
+type Location struct {
+	Latitude           float64
+	Longitude          float64
+	Altitude           float64
+	AreaCode           string
+	City               string
+	CountryCode        string
+	CountryName        string
+	HorizontalAccuracy float64
+	VerticalAccuracy   float64
+	RegionCode         string
+	RegionName         string
+	ZipPostalCode      string
+}
+
+// SearchMetadata carries metadata about a search query, such as the
+// locale and form factor of the client that issued it.
+type SearchMetadata struct {
+	m unsafe.Pointer
+}
+
+func finalizeSearchMetadata(metadata *SearchMetadata) {
+	C.destroy_search_metadata(metadata.m)
+}
+
+func makeSearchMetadata(ptr *C._SearchMetadata) *SearchMetadata {
+	metadata := &SearchMetadata{unsafe.Pointer(ptr)}
+	return metadata
+}
+
+// NewSearchMetadata creates a new SearchMetadata with the given
+// cardinality, locale and form factor.
+func NewSearchMetadata(cardinality int, locale, formFactor string) *SearchMetadata {
+	metadata := new(SearchMetadata)
+	runtime.SetFinalizer(metadata, finalizeSearchMetadata)
+	metadata.m = C.new_search_metadata(C.int(cardinality), unsafe.Pointer(&locale), unsafe.Pointer(&formFactor))
+	return metadata
+}
+
+// Locale returns the locale of the client that issued the search.
+func (metadata *SearchMetadata) Locale() string {
+	s := C.search_metadata_locale(metadata.m)
+	defer C.free(unsafe.Pointer(s))
+	return C.GoString(s)
+}
+
+// FormFactor returns the form factor of the client that issued the search.
+func (metadata *SearchMetadata) FormFactor() string {
+	s := C.search_metadata_form_factor(metadata.m)
+	defer C.free(unsafe.Pointer(s))
+	return C.GoString(s)
+}
+
+// Cardinality returns the number of results requested by the client,
+// or 0 if the client did not request a specific number.
+func (metadata *SearchMetadata) Cardinality() int {
+	return int(C.search_metadata_cardinality(metadata.m))
+}
+
+// Location returns the client's location, or nil if no location was provided.
+func (metadata *SearchMetadata) Location() *Location {
+	var hasLocation C.int
+	var errorString *C.char
+	data := C.search_metadata_location(metadata.m, &hasLocation, &errorString)
+	if err := checkError(errorString); err != nil || hasLocation == 0 {
+		return nil
+	}
+	defer C.free(unsafe.Pointer(data))
+	location := new(Location)
+	if err := json.Unmarshal([]byte(C.GoString(data)), location); err != nil {
+		return nil
+	}
+	return location
+}
+
+// SetLocation sets the client's location.
+func (metadata *SearchMetadata) SetLocation(location *Location) error {
+	data, err := json.Marshal(location)
+	if err != nil {
+		return err
+	}
+	value := string(data)
+	var errorString *C.char
+	C.search_metadata_set_location(metadata.m, unsafe.Pointer(&value), &errorString)
+	return checkError(errorString)
+}
+
+// Timeout returns the amount of time the C++ runtime allots to this
+// search before the associated context is cancelled, or 0 if the
+// runtime did not supply a deadline.
+//
+// Unlike ActionMetadata.Timeout, this does not fall back to a
+// "timeout_ms" hint: SearchMetadata does not expose a Hints/GetHint
+// accessor in the underlying library, so there is nothing to fall
+// back to.
+func (metadata *SearchMetadata) Timeout() time.Duration {
+	return time.Duration(C.search_metadata_timeout_ms(metadata.m)) * time.Millisecond
+}
+
+// ActionMetadata carries metadata passed alongside preview, activation
+// and preview-action requests.
+type ActionMetadata struct {
+	m unsafe.Pointer
+}
+
+func finalizeActionMetadata(metadata *ActionMetadata) {
+	C.destroy_action_metadata(metadata.m)
+}
+
+func makeActionMetadata(ptr *C._ActionMetadata) *ActionMetadata {
+	metadata := &ActionMetadata{unsafe.Pointer(ptr)}
+	return metadata
+}
+
+// NewActionMetadata creates a new ActionMetadata with the given
+// locale and form factor.
+func NewActionMetadata(locale, formFactor string) *ActionMetadata {
+	metadata := new(ActionMetadata)
+	runtime.SetFinalizer(metadata, finalizeActionMetadata)
+	metadata.m = C.new_action_metadata(unsafe.Pointer(&locale), unsafe.Pointer(&formFactor))
+	return metadata
+}
+
+// Locale returns the locale of the client.
+func (metadata *ActionMetadata) Locale() string {
+	s := C.action_metadata_locale(metadata.m)
+	defer C.free(unsafe.Pointer(s))
+	return C.GoString(s)
+}
+
+// FormFactor returns the form factor of the client.
+func (metadata *ActionMetadata) FormFactor() string {
+	s := C.action_metadata_form_factor(metadata.m)
+	defer C.free(unsafe.Pointer(s))
+	return C.GoString(s)
+}
+
+// ScopeData decodes the scope data attached to this request into the
+// given value, according to the same rules used by json.Unmarshal().
+func (metadata *ActionMetadata) ScopeData(value interface{}) error {
+	var errorString *C.char
+	data := C.action_metadata_scope_data(metadata.m, &errorString)
+	if err := checkError(errorString); err != nil {
+		return err
+	}
+	defer C.free(unsafe.Pointer(data))
+	return json.Unmarshal([]byte(C.GoString(data)), value)
+}
+
+// SetScopeData sets the scope data attached to this request.
+func (metadata *ActionMetadata) SetScopeData(value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	stringValue := string(data)
+	var errorString *C.char
+	C.action_metadata_set_scope_data(metadata.m, unsafe.Pointer(&stringValue), &errorString)
+	return checkError(errorString)
+}
+
+// Hints decodes all hints attached to this request into the given value.
+func (metadata *ActionMetadata) Hints(value interface{}) error {
+	var errorString *C.char
+	data := C.action_metadata_hints(metadata.m, &errorString)
+	if err := checkError(errorString); err != nil {
+		return err
+	}
+	defer C.free(unsafe.Pointer(data))
+	return json.Unmarshal([]byte(C.GoString(data)), value)
+}
+
+// SetHint sets the named hint.
+func (metadata *ActionMetadata) SetHint(key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	stringValue := string(data)
+	var errorString *C.char
+	C.action_metadata_set_hint(metadata.m, unsafe.Pointer(&key), unsafe.Pointer(&stringValue), &errorString)
+	return checkError(errorString)
+}
+
+// GetHint decodes the named hint into the given value.
+//
+// An error is returned if the hint does not exist.
+func (metadata *ActionMetadata) GetHint(key string, value interface{}) error {
+	var errorString *C.char
+	data := C.action_metadata_get_hint(metadata.m, unsafe.Pointer(&key), &errorString)
+	if err := checkError(errorString); err != nil {
+		return err
+	}
+	defer C.free(unsafe.Pointer(data))
+	return json.Unmarshal([]byte(C.GoString(data)), value)
+}
+
+// Timeout returns the amount of time the C++ runtime allots to this
+// request before the associated context is cancelled, or 0 if the
+// runtime did not supply a deadline.  If the runtime binding itself
+// does not report a deadline, this falls back to a "timeout_ms" hint,
+// for runtimes that only surface the deadline that way.
+func (metadata *ActionMetadata) Timeout() time.Duration {
+	if ms := C.action_metadata_timeout_ms(metadata.m); ms > 0 {
+		return time.Duration(ms) * time.Millisecond
+	}
+	var ms int64
+	if err := metadata.GetHint("timeout_ms", &ms); err == nil && ms > 0 {
+		return time.Duration(ms) * time.Millisecond
+	}
+	return 0
+}
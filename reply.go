@@ -0,0 +1,246 @@
+package scopes
+
+// #include <stdlib.h>
+// #include "shim.h"
+import "C"
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+// replyBase holds the state shared by SearchReply and PreviewReply.
+type replyBase struct {
+	r unsafe.Pointer
+}
+
+func makeReplyBase(replyData *C.uintptr_t) replyBase {
+	return replyBase{r: unsafe.Pointer(uintptr(*replyData))}
+}
+
+// Error tells the client that the request failed with the given error.
+func (reply *replyBase) Error(err error) {
+	message := err.Error()
+	C.reply_error(reply.r, unsafe.Pointer(&message))
+}
+
+// Finished tells the client that the request completed successfully.
+func (reply *replyBase) Finished() {
+	C.reply_finished(reply.r)
+}
+
+// ReplyMode controls what SearchReply.Push and SearchReply.PushBatch
+// do once the high-water mark set with SetHighWaterMark has been
+// reached.
+type ReplyMode int
+
+const (
+	// ReplyModeBlocking, the default, makes Push and PushBatch block
+	// until the client has flushed enough outstanding results to
+	// fall back under the high-water mark, or until the associated
+	// context (if any) is cancelled.
+	ReplyModeBlocking ReplyMode = iota
+	// ReplyModeNonBlocking makes Push and PushBatch return
+	// ErrWouldBlock instead of blocking once the high-water mark has
+	// been reached.
+	ReplyModeNonBlocking
+)
+
+// ErrWouldBlock is returned by SearchReply.Push and
+// SearchReply.PushBatch in ReplyModeNonBlocking when pushing another
+// result would exceed the configured high-water mark.
+var ErrWouldBlock = errors.New("scopes: push would block")
+
+var (
+	searchRepliesLock sync.Mutex
+	searchReplies     = make(map[uintptr]*SearchReply)
+)
+
+// SearchReply is used by Scope.Search (or ScopeV2.Search) to push
+// results back to the client.
+type SearchReply struct {
+	replyBase
+	id uintptr
+
+	mu            sync.Mutex
+	cond          *sync.Cond
+	outstanding   int
+	highWaterMark int
+	mode          ReplyMode
+	ctx           context.Context
+}
+
+func finalizeSearchReply(reply *SearchReply) {
+	reply.release()
+	C.destroy_reply(reply.r)
+}
+
+// release removes reply from searchReplies, so it no longer keeps the
+// value reachable for callReplyResultFlushed once the request has
+// finished.  It is called from Error and Finished; calling it again
+// from the GC finalizer is harmless.
+func (reply *SearchReply) release() {
+	searchRepliesLock.Lock()
+	delete(searchReplies, reply.id)
+	searchRepliesLock.Unlock()
+}
+
+// Error tells the client that the request failed with the given error.
+func (reply *SearchReply) Error(err error) {
+	reply.release()
+	reply.replyBase.Error(err)
+}
+
+// Finished tells the client that the request completed successfully.
+func (reply *SearchReply) Finished() {
+	reply.release()
+	reply.replyBase.Finished()
+}
+
+func makeSearchReply(replyData *C.uintptr_t) *SearchReply {
+	reply := &SearchReply{replyBase: makeReplyBase(replyData), id: uintptr(*replyData), ctx: context.Background()}
+	reply.cond = sync.NewCond(&reply.mu)
+	searchRepliesLock.Lock()
+	searchReplies[reply.id] = reply
+	searchRepliesLock.Unlock()
+	runtime.SetFinalizer(reply, finalizeSearchReply)
+	return reply
+}
+
+// bindContext associates reply with the context of the Search call
+// that owns it, so that cancelling ctx unblocks any Push or PushBatch
+// call currently waiting on the high-water mark.
+func (reply *SearchReply) bindContext(ctx context.Context) {
+	reply.ctx = ctx
+	go func() {
+		<-ctx.Done()
+		reply.mu.Lock()
+		reply.cond.Broadcast()
+		reply.mu.Unlock()
+	}()
+}
+
+//export callReplyResultFlushed
+func callReplyResultFlushed(id C.uintptr_t) {
+	searchRepliesLock.Lock()
+	reply := searchReplies[uintptr(id)]
+	searchRepliesLock.Unlock()
+	if reply == nil {
+		return
+	}
+	reply.mu.Lock()
+	reply.outstanding--
+	reply.cond.Broadcast()
+	reply.mu.Unlock()
+}
+
+// SetHighWaterMark sets the number of pushed-but-not-yet-flushed
+// results allowed before Push and PushBatch start applying
+// backpressure.  A value of 0, the default, disables backpressure.
+func (reply *SearchReply) SetHighWaterMark(n int) {
+	reply.mu.Lock()
+	reply.highWaterMark = n
+	reply.cond.Broadcast()
+	reply.mu.Unlock()
+}
+
+// SetMode selects whether Push and PushBatch block or return
+// ErrWouldBlock once the high-water mark has been reached.
+func (reply *SearchReply) SetMode(mode ReplyMode) {
+	reply.mu.Lock()
+	reply.mode = mode
+	reply.mu.Unlock()
+}
+
+// acquireSlot blocks until fewer than highWaterMark results are
+// outstanding, then registers one (incrementing outstanding) before
+// returning, so that checking the mark and claiming a slot happen
+// atomically under the same critical section: otherwise concurrent
+// Push/PushBatch callers could all pass the check before any of them
+// incremented outstanding, letting it overshoot highWaterMark.  It
+// returns an error without registering a slot if it should not push:
+// either ErrWouldBlock in ReplyModeNonBlocking, or the reply's context
+// error if it has been cancelled.
+func (reply *SearchReply) acquireSlot() error {
+	reply.mu.Lock()
+	defer reply.mu.Unlock()
+	for reply.highWaterMark > 0 && reply.outstanding >= reply.highWaterMark {
+		if err := reply.ctx.Err(); err != nil {
+			return err
+		}
+		if reply.mode == ReplyModeNonBlocking {
+			return ErrWouldBlock
+		}
+		reply.cond.Wait()
+	}
+	if err := reply.ctx.Err(); err != nil {
+		return err
+	}
+	reply.outstanding++
+	return nil
+}
+
+// releaseSlot undoes a slot claimed by acquireSlot when the push that
+// followed it failed, so a failed push isn't counted against the
+// high-water mark.
+func (reply *SearchReply) releaseSlot() {
+	reply.mu.Lock()
+	reply.outstanding--
+	reply.cond.Broadcast()
+	reply.mu.Unlock()
+}
+
+// Push adds a single result to the category it belongs to.
+//
+// Once SetHighWaterMark has been called with a positive value, Push
+// blocks while the number of outstanding (pushed but not yet flushed)
+// results is at or above that mark, unless
+// SetMode(ReplyModeNonBlocking) was called, in which case it returns
+// ErrWouldBlock instead.  A cancelled context also unblocks a pending
+// Push, which then returns the context's error.
+func (reply *SearchReply) Push(result *CategorisedResult) error {
+	if err := reply.acquireSlot(); err != nil {
+		return err
+	}
+	var errorString *C.char
+	C.search_reply_push(reply.r, result.result, &errorString)
+	if err := checkError(errorString); err != nil {
+		reply.releaseSlot()
+		return err
+	}
+	return nil
+}
+
+// PushBatch pushes results in order, applying the same backpressure
+// as Push to each one.  It returns the number of results actually
+// pushed.  If a push fails partway through the batch, either because
+// it hit ErrWouldBlock in ReplyModeNonBlocking or because the
+// underlying Push returned an error, PushBatch stops and returns that
+// error alongside the count of results pushed so far.
+func (reply *SearchReply) PushBatch(results []*CategorisedResult) (pushed int, err error) {
+	for _, result := range results {
+		if err = reply.Push(result); err != nil {
+			return pushed, err
+		}
+		pushed++
+	}
+	return pushed, nil
+}
+
+// PreviewReply is used by Scope.Preview (or ScopeV2.Preview) to send
+// preview widgets back to the client.
+type PreviewReply struct {
+	replyBase
+}
+
+func finalizePreviewReply(reply *PreviewReply) {
+	C.destroy_reply(reply.r)
+}
+
+func makePreviewReply(replyData *C.uintptr_t) *PreviewReply {
+	reply := &PreviewReply{replyBase: makeReplyBase(replyData)}
+	runtime.SetFinalizer(reply, finalizePreviewReply)
+	return reply
+}
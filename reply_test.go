@@ -0,0 +1,178 @@
+// Package-internal (rather than the gocheck-based scopes_test package
+// used by metadata_test.go) because these tests exercise acquireSlot,
+// releaseSlot and the outstanding/cond bookkeeping directly, and those
+// are unexported: there is no way to drive or observe them through
+// SearchReply's public API without a live C++ reply behind it.
+
+package scopes
+
+// #include <stdlib.h>
+// #include "shim.h"
+import "C"
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestSearchReply builds a SearchReply with just enough state for
+// exercising the backpressure bookkeeping, without going through
+// makeSearchReply (which requires a live C++ reply behind replyData).
+func newTestSearchReply(id uintptr) *SearchReply {
+	reply := &SearchReply{id: id, ctx: context.Background()}
+	reply.cond = sync.NewCond(&reply.mu)
+	return reply
+}
+
+func TestAcquireSlotNoLimit(t *testing.T) {
+	reply := newTestSearchReply(1)
+	if err := reply.acquireSlot(); err != nil {
+		t.Fatalf("acquireSlot with no high-water mark: got %v, want nil", err)
+	}
+	if reply.outstanding != 1 {
+		t.Fatalf("outstanding after acquireSlot: got %d, want 1", reply.outstanding)
+	}
+}
+
+func TestAcquireSlotBlocksUntilFlush(t *testing.T) {
+	reply := newTestSearchReply(2)
+	reply.SetHighWaterMark(1)
+	reply.outstanding = 1
+
+	done := make(chan error, 1)
+	go func() { done <- reply.acquireSlot() }()
+
+	select {
+	case err := <-done:
+		t.Fatalf("acquireSlot returned early with err=%v before capacity freed up", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	reply.mu.Lock()
+	reply.outstanding--
+	reply.cond.Broadcast()
+	reply.mu.Unlock()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("acquireSlot after flush: got %v, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("acquireSlot did not unblock after outstanding dropped below the high-water mark")
+	}
+
+	reply.mu.Lock()
+	outstanding := reply.outstanding
+	reply.mu.Unlock()
+	if outstanding != 1 {
+		t.Fatalf("outstanding after acquireSlot unblocked: got %d, want 1", outstanding)
+	}
+}
+
+func TestAcquireSlotNonBlockingReturnsErrWouldBlock(t *testing.T) {
+	reply := newTestSearchReply(3)
+	reply.SetHighWaterMark(1)
+	reply.SetMode(ReplyModeNonBlocking)
+	reply.outstanding = 1
+
+	if err := reply.acquireSlot(); err != ErrWouldBlock {
+		t.Fatalf("acquireSlot in ReplyModeNonBlocking: got %v, want ErrWouldBlock", err)
+	}
+	if reply.outstanding != 1 {
+		t.Fatalf("outstanding after a rejected acquireSlot: got %d, want unchanged 1", reply.outstanding)
+	}
+}
+
+func TestAcquireSlotUnblockedByCancelledContext(t *testing.T) {
+	reply := newTestSearchReply(4)
+	reply.SetHighWaterMark(1)
+	reply.outstanding = 1
+	ctx, cancel := context.WithCancel(context.Background())
+	reply.bindContext(ctx)
+
+	done := make(chan error, 1)
+	go func() { done <- reply.acquireSlot() }()
+
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Fatalf("acquireSlot after cancel: got %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("acquireSlot did not unblock after the context was cancelled")
+	}
+}
+
+func TestAcquireSlotConcurrentCallersDoNotOvershootHighWaterMark(t *testing.T) {
+	reply := newTestSearchReply(6)
+	reply.SetHighWaterMark(2)
+
+	const callers = 50
+	var wg sync.WaitGroup
+	maxOutstanding := 0
+
+	wg.Add(callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			defer wg.Done()
+			if err := reply.acquireSlot(); err != nil {
+				t.Errorf("acquireSlot: unexpected error %v", err)
+				return
+			}
+
+			// The check and the increment inside acquireSlot must
+			// happen under the same critical section: otherwise
+			// concurrent callers could all pass the check before any
+			// of them incremented outstanding, letting it overshoot
+			// the high-water mark.
+			reply.mu.Lock()
+			if reply.outstanding > maxOutstanding {
+				maxOutstanding = reply.outstanding
+			}
+			reply.mu.Unlock()
+
+			// Simulate the C++ side flushing this result shortly
+			// after it was pushed, freeing the slot for another
+			// caller.
+			time.Sleep(time.Millisecond)
+			reply.mu.Lock()
+			reply.outstanding--
+			reply.cond.Broadcast()
+			reply.mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if maxOutstanding > 2 {
+		t.Fatalf("outstanding overshot the high-water mark: peaked at %d, want at most 2", maxOutstanding)
+	}
+}
+
+func TestCallReplyResultFlushedDecrementsOutstanding(t *testing.T) {
+	reply := newTestSearchReply(5)
+	reply.outstanding = 2
+
+	searchRepliesLock.Lock()
+	searchReplies[reply.id] = reply
+	searchRepliesLock.Unlock()
+	defer reply.release()
+
+	callReplyResultFlushed(C.uintptr_t(reply.id))
+
+	reply.mu.Lock()
+	outstanding := reply.outstanding
+	reply.mu.Unlock()
+	if outstanding != 1 {
+		t.Fatalf("outstanding after one flush: got %d, want 1", outstanding)
+	}
+}
+
+func TestCallReplyResultFlushedUnknownIDIsNoop(t *testing.T) {
+	// An id with no registered SearchReply (e.g. one that already
+	// called Finished/Error) must be ignored rather than panicking.
+	callReplyResultFlushed(C.uintptr_t(0xdeadbeef))
+}
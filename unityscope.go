@@ -8,12 +8,14 @@ package scopes
 */
 import "C"
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"flag"
 	"path"
 	"strings"
 	"sync"
+	"time"
 	"unsafe"
 )
 
@@ -34,35 +36,125 @@ func finalizeCategory(cat *Category) {
 	C.destroy_category_ptr(&cat.c[0])
 }
 
-// Scope defines the interface that scope implementations must implement
-type Scope interface {
+// scopeBase is the common subset shared by Scope and ScopeV2.  It lets
+// the runtime accept either version of the interface while the calling
+// convention (raw channel vs. context.Context) is resolved with a type
+// switch at each call site.
+type scopeBase interface {
 	SetScopeBase(base *ScopeBase)
+}
+
+// Scope defines the interface that scope implementations must implement.
+//
+// Deprecated: implement ScopeV2 instead.  ScopeV2 replaces the bare
+// cancellation channel with a context.Context, which also carries a
+// deadline when the caller set a timeout.  Scope is kept working for
+// the duration of a deprecation period and will eventually be removed.
+type Scope interface {
+	scopeBase
 	Search(query *CannedQuery, metadata *SearchMetadata, reply *SearchReply, cancelled <-chan bool) error
 	Preview(result *Result, metadata *ActionMetadata, reply *PreviewReply, cancelled <-chan bool) error
 }
 
+// ScopeV2 is the context-aware successor to Scope.  Search and Preview
+// receive a context.Context that is cancelled when the C++ runtime
+// signals cancellation, or when the request's timeout (if any)
+// elapses.  Handlers should return ctx.Err() (context.Canceled or
+// context.DeadlineExceeded) when they bail out early because of it, so
+// that reply.Error() receives a meaningful reason.
+type ScopeV2 interface {
+	scopeBase
+	Search(ctx context.Context, query *CannedQuery, metadata *SearchMetadata, reply *SearchReply) error
+	Preview(ctx context.Context, result *Result, metadata *ActionMetadata, reply *PreviewReply) error
+}
+
 // Activator is an interface that should be implemented by scopes that
 // need to handle result activation directly.
+//
+// Deprecated: implement ActivatorV2 instead.
 type Activator interface {
 	Scope
 	Activate(result *Result, metadata *ActionMetadata) (*ActivationResponse, error)
 }
 
+// ActivatorV2 is the context-aware successor to Activator.
+type ActivatorV2 interface {
+	ScopeV2
+	Activate(ctx context.Context, result *Result, metadata *ActionMetadata) (*ActivationResponse, error)
+}
+
 // PerformActioner is an interface that should be implemented by
 // scopes that need to handle preview actions directly.
+//
+// Deprecated: implement PerformActionerV2 instead.
 type PerformActioner interface {
 	Scope
 	PerformAction(result *Result, metadata *ActionMetadata, widgetId, actionId string) (*ActivationResponse, error)
 }
 
+// PerformActionerV2 is the context-aware successor to PerformActioner.
+type PerformActionerV2 interface {
+	ScopeV2
+	PerformAction(ctx context.Context, result *Result, metadata *ActionMetadata, widgetId, actionId string) (*ActivationResponse, error)
+}
+
+// contextForCall builds the context.Context passed to a ScopeV2
+// handler for a single Search or Preview invocation.  The context is
+// cancelled either when the C++ runtime signals cancellation on
+// cancel, or when timeout (if non-zero) elapses.  The returned cancel
+// function must be called once the invocation has finished, to
+// release the watcher goroutine and any timer.
+func contextForCall(cancel <-chan bool, timeout time.Duration) (context.Context, context.CancelFunc) {
+	var ctx context.Context
+	var cancelCtx context.CancelFunc
+	if timeout > 0 {
+		ctx, cancelCtx = context.WithTimeout(context.Background(), timeout)
+	} else {
+		ctx, cancelCtx = context.WithCancel(context.Background())
+	}
+	go func() {
+		select {
+		case <-cancel:
+			cancelCtx()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancelCtx
+}
+
 //export callScopeSearch
-func callScopeSearch(scope Scope, queryPtr *C._CannedQuery, metadataPtr *C._SearchMetadata, replyData *C.uintptr_t, cancel <-chan bool) {
+func callScopeSearch(scope scopeBase, queryPtr *C._CannedQuery, metadataPtr *C._SearchMetadata, replyData *C.uintptr_t, cancel <-chan bool) {
 	query := makeCannedQuery(queryPtr)
 	metadata := makeSearchMetadata(metadataPtr)
 	reply := makeSearchReply(replyData)
 
 	go func() {
-		err := scope.Search(query, metadata, reply, cancel)
+		// bindContext is wired up for both interface versions so that
+		// a legacy Scope's Push/PushBatch calls still unblock when the
+		// C++ side sends its native cancel signal, not just when a
+		// ScopeV2 handler's context is done.
+		ctx, cancelCtx := contextForCall(cancel, metadata.Timeout())
+		defer cancelCtx()
+		reply.bindContext(ctx)
+
+		var err error
+		if v2, ok := scope.(ScopeV2); ok {
+			err = v2.Search(ctx, query, metadata, reply)
+		} else if v1, ok := scope.(Scope); ok {
+			// contextForCall's watcher consumes the single value sent
+			// on cancel, so forward a C++ cancellation to the legacy
+			// handler on a channel of its own.
+			legacyCancel := make(chan bool, 1)
+			go func() {
+				<-ctx.Done()
+				if ctx.Err() == context.Canceled {
+					legacyCancel <- true
+				}
+			}()
+			err = v1.Search(query, metadata, reply, legacyCancel)
+		} else {
+			err = errors.New("scope implements neither Scope nor ScopeV2")
+		}
 		if err != nil {
 			reply.Error(err)
 			return
@@ -72,13 +164,22 @@ func callScopeSearch(scope Scope, queryPtr *C._CannedQuery, metadataPtr *C._Sear
 }
 
 //export callScopePreview
-func callScopePreview(scope Scope, resultPtr *C._Result, metadataPtr *C._ActionMetadata, replyData *C.uintptr_t, cancel <-chan bool) {
+func callScopePreview(scope scopeBase, resultPtr *C._Result, metadataPtr *C._ActionMetadata, replyData *C.uintptr_t, cancel <-chan bool) {
 	result := makeResult(resultPtr)
 	metadata := makeActionMetadata(metadataPtr)
 	reply := makePreviewReply(replyData)
 
 	go func() {
-		err := scope.Preview(result, metadata, reply, cancel)
+		var err error
+		if v2, ok := scope.(ScopeV2); ok {
+			ctx, cancelCtx := contextForCall(cancel, metadata.Timeout())
+			defer cancelCtx()
+			err = v2.Preview(ctx, result, metadata, reply)
+		} else if v1, ok := scope.(Scope); ok {
+			err = v1.Preview(result, metadata, reply, cancel)
+		} else {
+			err = errors.New("scope implements neither Scope nor ScopeV2")
+		}
 		if err != nil {
 			reply.Error(err)
 			return
@@ -88,38 +189,60 @@ func callScopePreview(scope Scope, resultPtr *C._Result, metadataPtr *C._ActionM
 }
 
 //export callScopeActivate
-func callScopeActivate(scope Scope, resultPtr *C._Result, metadataPtr *C._ActionMetadata, responsePtr *C._ActivationResponse, errorPtr **C.char) {
+func callScopeActivate(scope scopeBase, resultPtr *C._Result, metadataPtr *C._ActionMetadata, responsePtr *C._ActivationResponse, errorPtr **C.char) {
+	result := makeResult(resultPtr)
+	metadata := makeActionMetadata(metadataPtr)
+
+	var response *ActivationResponse
+	var err error
 	switch s := scope.(type) {
-	case Activator:
-		result := makeResult(resultPtr)
-		metadata := makeActionMetadata(metadataPtr)
-		response, err := s.Activate(result, metadata)
-		if err == nil {
-			err = response.update(responsePtr)
-		}
-		if err != nil {
-			*errorPtr = C.CString(err.Error())
+	case ActivatorV2:
+		ctx := context.Background()
+		if timeout := metadata.Timeout(); timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
 		}
+		response, err = s.Activate(ctx, result, metadata)
+	case Activator:
+		response, err = s.Activate(result, metadata)
 	default:
-		// nothing
+		return
+	}
+	if err == nil {
+		err = response.update(responsePtr)
+	}
+	if err != nil {
+		*errorPtr = C.CString(err.Error())
 	}
 }
 
 //export callScopePerformAction
-func callScopePerformAction(scope Scope, resultPtr *C._Result, metadataPtr *C._ActionMetadata, widgetId, actionId *C.char, responsePtr *C._ActivationResponse, errorPtr **C.char) {
+func callScopePerformAction(scope scopeBase, resultPtr *C._Result, metadataPtr *C._ActionMetadata, widgetId, actionId *C.char, responsePtr *C._ActivationResponse, errorPtr **C.char) {
+	result := makeResult(resultPtr)
+	metadata := makeActionMetadata(metadataPtr)
+
+	var response *ActivationResponse
+	var err error
 	switch s := scope.(type) {
-	case PerformActioner:
-		result := makeResult(resultPtr)
-		metadata := makeActionMetadata(metadataPtr)
-		response, err := s.PerformAction(result, metadata, C.GoString(widgetId), C.GoString(actionId))
-		if err == nil {
-			err = response.update(responsePtr)
-		}
-		if err != nil {
-			*errorPtr = C.CString(err.Error())
+	case PerformActionerV2:
+		ctx := context.Background()
+		if timeout := metadata.Timeout(); timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
 		}
+		response, err = s.PerformAction(ctx, result, metadata, C.GoString(widgetId), C.GoString(actionId))
+	case PerformActioner:
+		response, err = s.PerformAction(result, metadata, C.GoString(widgetId), C.GoString(actionId))
 	default:
-		// nothing
+		return
+	}
+	if err == nil {
+		err = response.update(responsePtr)
+	}
+	if err != nil {
+		*errorPtr = C.CString(err.Error())
 	}
 }
 
@@ -135,7 +258,7 @@ type ScopeBase struct {
 }
 
 //export setScopeBase
-func setScopeBase(scope Scope, b unsafe.Pointer) {
+func setScopeBase(scope scopeBase, b unsafe.Pointer) {
 	if b == nil {
 		scope.SetScopeBase(nil)
 	} else {
@@ -177,9 +300,15 @@ func (b *ScopeBase) Settings(value interface{}) error {
 /*
 Run will initialise the scope runtime and make a scope availble.  It
 is intended to be called from the program's main function, and will
-run until the program exits.
+run until the program exits.  scope may implement either Scope or
+ScopeV2, and Activator/PerformActioner or their V2 counterparts.
 */
-func Run(scope Scope) error {
+func Run(scope scopeBase) error {
+	if _, ok := scope.(Scope); !ok {
+		if _, ok := scope.(ScopeV2); !ok {
+			return errors.New("scope implements neither Scope nor ScopeV2")
+		}
+	}
 	if !flag.Parsed() {
 		flag.Parse()
 	}